@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/common"
+	lutils "github.com/hyperledger/fabric/core/ledger/util"
+	"github.com/hyperledger/fabric/protoutil"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConstructConfigBlock checks that constructConfigBlock derives a new Config from the given
+// configUpdate (rather than taking one on faith): a new channel-group value introduced by the
+// update's write set is added with version 1 and the channel group's own version is bumped, while
+// every value the genesis config already had survives the transition untouched. It also checks
+// the surrounding block plumbing: block number, previous hash, and TRANSACTIONS_FILTER metadata.
+func TestConstructConfigBlock(t *testing.T) {
+	channelID := "dummyChannel"
+	genesisBlock, err := constructTestGenesisBlock(channelID)
+	require.NoError(t, err)
+
+	genesisConfigEnv, err := extractConfigEnvelope(genesisBlock)
+	require.NoError(t, err)
+	prevChannelGroup := genesisConfigEnv.Config.ChannelGroup
+
+	configUpdate := &common.ConfigUpdate{
+		ChannelId: channelID,
+		ReadSet:   &common.ConfigGroup{Version: prevChannelGroup.Version},
+		WriteSet: &common.ConfigGroup{
+			Version: prevChannelGroup.Version,
+			Values: map[string]*common.ConfigValue{
+				"TestMarker": {Value: []byte("added-by-test")},
+			},
+		},
+	}
+
+	configBlock, err := constructConfigBlock(genesisBlock, configUpdate)
+	require.NoError(t, err)
+
+	require.Equal(t, genesisBlock.Header.Number+1, configBlock.Header.Number)
+	require.Equal(t, protoutil.BlockHeaderHash(genesisBlock.Header), configBlock.Header.PreviousHash)
+	require.Len(t, configBlock.Data.Data, 1)
+
+	newConfigEnv, err := extractConfigEnvelope(configBlock)
+	require.NoError(t, err)
+
+	require.Equal(t, genesisConfigEnv.Config.Sequence+1, newConfigEnv.Config.Sequence)
+	require.Equal(t, prevChannelGroup.Version+1, newConfigEnv.Config.ChannelGroup.Version)
+
+	newValue := newConfigEnv.Config.ChannelGroup.Values["TestMarker"]
+	require.NotNil(t, newValue)
+	require.Equal(t, []byte("added-by-test"), newValue.Value)
+	require.EqualValues(t, 1, newValue.Version)
+
+	for key, prevValue := range prevChannelGroup.Values {
+		require.Equal(t, prevValue, newConfigEnv.Config.ChannelGroup.Values[key])
+	}
+
+	txFilter := lutils.TxValidationFlags(configBlock.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER])
+	require.True(t, txFilter.IsValid(0))
+}
@@ -0,0 +1,33 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/msp/mgmt"
+	msptesttools "github.com/hyperledger/fabric/msp/mgmt/testtools"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConstructSignedTxEnvWithMSP builds a transaction envelope signed by a real MSP signing
+// identity (loaded from msp/sampleconfig via msp/mgmt/testtools) and checks that the resulting
+// envelope verifies against that same identity, confirming withSigner threads a real signer all
+// the way through CreateProposalResponse and CreateSignedTx instead of the fakes.Signer.
+func TestConstructSignedTxEnvWithMSP(t *testing.T) {
+	require.NoError(t, msptesttools.LoadMSPSetupForTesting())
+	signingIdentity := mgmt.GetLocalSigningIdentityOrPanic(factory.GetDefault())
+
+	ccid := &peer.ChaincodeID{Name: "dummyCC", Version: "dummyVer"}
+	env, txid, err := constructSignedTxEnvWithMSP("dummyChannel", ccid, []byte("results"), "", signingIdentity)
+	require.NoError(t, err)
+	require.NotEmpty(t, txid)
+
+	require.NoError(t, signingIdentity.Verify(env.Payload, env.Signature))
+}
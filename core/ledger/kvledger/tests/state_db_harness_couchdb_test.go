@@ -0,0 +1,31 @@
+// +build couchdb
+
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestForEachStateDBBackend runs the same smoke scenario against both the LevelDB and CouchDB
+// backed stateDBHarness implementations, confirming the parameterized helper actually drives
+// both state DB code paths rather than only the default one. Requires a CouchDB docker image,
+// hence the couchdb build tag.
+func TestForEachStateDBBackend(t *testing.T) {
+	seen := map[string]bool{}
+	forEachStateDBBackend(t, t.TempDir(), t.TempDir(), func(t *testing.T, h stateDBHarness) {
+		cfg := h.Config()
+		require.NotEmpty(t, cfg.StateDBConfig.StateDatabase)
+		seen[cfg.StateDBConfig.StateDatabase] = true
+		h.Drop(t)
+	})
+	require.True(t, seen["goleveldb"])
+	require.True(t, seen["CouchDB"])
+}
@@ -7,8 +7,12 @@ SPDX-License-Identifier: Apache-2.0
 package tests
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric-protos-go/common"
@@ -20,11 +24,13 @@ import (
 	"github.com/hyperledger/fabric/common/crypto"
 	"github.com/hyperledger/fabric/common/flogging"
 	"github.com/hyperledger/fabric/common/metrics/disabled"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/kvledger/tests/fakes"
 	lutils "github.com/hyperledger/fabric/core/ledger/util"
 	"github.com/hyperledger/fabric/core/ledger/util/couchdb"
 	"github.com/hyperledger/fabric/integration/runner"
 	"github.com/hyperledger/fabric/protoutil"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -35,9 +41,15 @@ var logger = flogging.MustGetLogger("test2")
 // use 'collConf' as parameters and return values and transform back and forth to/from proto
 // message internally (using func 'convertToCollConfigProtoBytes' and 'convertFromCollConfigProto')
 type collConf struct {
-	name    string
-	btl     uint64
-	members []string
+	name              string
+	btl               uint64
+	members           []string
+	requiredNumOrgs   int32 // number of member orgs that must sign; zero defaults to 1 (OR-of-all), negative is a caller error
+	requiredPeerCount int32
+	maximumPeerCount  int32
+	memberOnlyRead    bool
+	memberOnlyWrite   bool
+	endorsers         []string // orgs that must endorse for this collection; nil means 'no dedicated endorsement policy'
 }
 
 type txAndPvtdata struct {
@@ -56,12 +68,21 @@ type signer interface {
 func convertToCollConfigProtoBytes(collConfs []*collConf) ([]byte, error) {
 	var protoConfArray []*common.CollectionConfig
 	for _, c := range collConfs {
+		memberOrgsPolicy, err := convertToMemberOrgsPolicy(c.members, c.requiredNumOrgs)
+		if err != nil {
+			return nil, err
+		}
 		protoConf := &common.CollectionConfig{
 			Payload: &common.CollectionConfig_StaticCollectionConfig{
 				StaticCollectionConfig: &common.StaticCollectionConfig{
-					Name:             c.name,
-					BlockToLive:      c.btl,
-					MemberOrgsPolicy: convertToMemberOrgsPolicy(c.members),
+					Name:              c.name,
+					BlockToLive:       c.btl,
+					MemberOrgsPolicy:  memberOrgsPolicy,
+					RequiredPeerCount: c.requiredPeerCount,
+					MaximumPeerCount:  c.maximumPeerCount,
+					MemberOnlyRead:    c.memberOnlyRead,
+					MemberOnlyWrite:   c.memberOnlyWrite,
+					EndorsementPolicy: convertToCollEndorsementPolicy(c.endorsers),
 				},
 			},
 		}
@@ -70,38 +91,80 @@ func convertToCollConfigProtoBytes(collConfs []*collConf) ([]byte, error) {
 	return proto.Marshal(&common.CollectionConfigPackage{Config: protoConfArray})
 }
 
-func convertToMemberOrgsPolicy(members []string) *common.CollectionPolicyConfig {
-	var data [][]byte
-	for _, member := range members {
-		data = append(data, []byte(member))
+// convertToMemberOrgsPolicy builds an N-of-M signature policy over the given members, where N is
+// requiredNumOrgs. A zero requiredNumOrgs defaults to 1 (i.e., an OR across all the members); a
+// negative requiredNumOrgs is rejected as a caller error.
+func convertToMemberOrgsPolicy(members []string, requiredNumOrgs int32) (*common.CollectionPolicyConfig, error) {
+	if requiredNumOrgs < 0 {
+		return nil, errors.Errorf("requiredNumOrgs must be >= 0, got %d", requiredNumOrgs)
+	}
+	data := make([][]byte, len(members))
+	signedBy := make([]*common.SignaturePolicy, len(members))
+	for i, member := range members {
+		data[i] = []byte(member)
+		signedBy[i] = cauthdsl.SignedBy(int32(i))
+	}
+	if requiredNumOrgs == 0 {
+		requiredNumOrgs = 1
 	}
 	return &common.CollectionPolicyConfig{
 		Payload: &common.CollectionPolicyConfig_SignaturePolicy{
-			SignaturePolicy: cauthdsl.Envelope(cauthdsl.Or(cauthdsl.SignedBy(0), cauthdsl.SignedBy(1)), data),
+			SignaturePolicy: cauthdsl.Envelope(cauthdsl.NOutOf(requiredNumOrgs, signedBy), data),
 		},
-	}
+	}, nil
 }
 
-func convertFromMemberOrgsPolicy(policy *common.CollectionPolicyConfig) []string {
-	if policy.GetSignaturePolicy() == nil {
+// convertToCollEndorsementPolicy builds an AND-of-all signature policy over the given endorsers.
+// It returns nil when no endorsers are given, leaving the collection to fall back to the
+// chaincode-level endorsement policy.
+func convertToCollEndorsementPolicy(endorsers []string) *common.CollectionEndorsementPolicy {
+	if len(endorsers) == 0 {
 		return nil
 	}
-	ids := policy.GetSignaturePolicy().Identities
-	var members []string
-	for _, id := range ids {
+	data := make([][]byte, len(endorsers))
+	signedBy := make([]*common.SignaturePolicy, len(endorsers))
+	for i, endorser := range endorsers {
+		data[i] = []byte(endorser)
+		signedBy[i] = cauthdsl.SignedBy(int32(i))
+	}
+	return &common.CollectionEndorsementPolicy{
+		Payload: &common.CollectionEndorsementPolicy_SignaturePolicy{
+			SignaturePolicy: cauthdsl.Envelope(cauthdsl.NOutOf(int32(len(endorsers)), signedBy), data),
+		},
+	}
+}
+
+// identitiesToOrgs extracts the org name encoded in each principal's Principal bytes.
+func identitiesToOrgs(identities []*msp.MSPPrincipal) []string {
+	var orgs []string
+	for _, id := range identities {
 		role := &msp.MSPRole{}
 		err := proto.Unmarshal(id.Principal, role)
 		if err == nil {
 			// This is for sample ledger generated by fabric (e.g., integration test),
 			// where id.Principal was properly marshalled during sample ledger generation.
-			members = append(members, role.MspIdentifier)
+			orgs = append(orgs, role.MspIdentifier)
 		} else {
 			// This is for sample ledger generated by sampleDataHelper.populateLedger,
 			// where id.Principal was a []byte cast from a string (not a marshalled msp.MSPRole)
-			members = append(members, string(id.Principal))
+			orgs = append(orgs, string(id.Principal))
 		}
 	}
-	return members
+	return orgs
+}
+
+func convertFromMemberOrgsPolicy(policy *common.CollectionPolicyConfig) ([]string, int32) {
+	if policy.GetSignaturePolicy() == nil {
+		return nil, 0
+	}
+	return identitiesToOrgs(policy.GetSignaturePolicy().Identities), policy.GetSignaturePolicy().Rule.GetNOutOf().GetN()
+}
+
+func convertFromCollEndorsementPolicy(policy *common.CollectionEndorsementPolicy) []string {
+	if policy.GetSignaturePolicy() == nil {
+		return nil
+	}
+	return identitiesToOrgs(policy.GetSignaturePolicy().Identities)
 }
 
 func convertFromCollConfigProto(collConfPkg *common.CollectionConfigPackage) []*collConf {
@@ -109,11 +172,18 @@ func convertFromCollConfigProto(collConfPkg *common.CollectionConfigPackage) []*
 	protoConfArray := collConfPkg.Config
 	for _, protoConf := range protoConfArray {
 		p := protoConf.GetStaticCollectionConfig()
+		members, requiredNumOrgs := convertFromMemberOrgsPolicy(p.MemberOrgsPolicy)
 		collConfs = append(collConfs,
 			&collConf{
-				name:    p.Name,
-				btl:     p.BlockToLive,
-				members: convertFromMemberOrgsPolicy(p.MemberOrgsPolicy),
+				name:              p.Name,
+				btl:               p.BlockToLive,
+				members:           members,
+				requiredNumOrgs:   requiredNumOrgs,
+				requiredPeerCount: p.RequiredPeerCount,
+				maximumPeerCount:  p.MaximumPeerCount,
+				memberOnlyRead:    p.MemberOnlyRead,
+				memberOnlyWrite:   p.MemberOnlyWrite,
+				endorsers:         convertFromCollEndorsementPolicy(p.EndorsementPolicy),
 			},
 		)
 	}
@@ -139,6 +209,52 @@ func constructTransaction(txid string, simulationResults []byte) (*common.Envelo
 	return txenv, err
 }
 
+// constructSignedTxEnvWithMSP is identical to constructTransaction except that it signs and
+// endorses the transaction with the given signing identity (e.g., a real msp.SigningIdentity
+// built from msp/mgmt/testtools sample MSPs) instead of the fakes.Signer used by default. This
+// lets tests build blocks whose transactions actually verify against a real MSP.
+func constructSignedTxEnvWithMSP(
+	channelID string,
+	ccid *protopeer.ChaincodeID,
+	simulationResults []byte,
+	txid string,
+	signingIdentity signer,
+) (*common.Envelope, string, error) {
+	return constructUnsignedTxEnv(
+		channelID,
+		ccid,
+		&protopeer.Response{Status: 200},
+		simulationResults,
+		txid,
+		nil,
+		nil,
+		common.HeaderType_ENDORSER_TRANSACTION,
+		withSigner(signingIdentity),
+	)
+}
+
+type txEnvConfig struct {
+	signer signer
+}
+
+type txEnvOpt func(*txEnvConfig)
+
+// withSigner overrides the signer used to build and endorse a transaction envelope. Passing a
+// real msp.SigningIdentity (which satisfies the signer interface) in place of the default
+// fakes.Signer lets the resulting transaction be verified against a real MSP.
+func withSigner(s signer) txEnvOpt {
+	return func(c *txEnvConfig) {
+		c.signer = s
+	}
+}
+
+func defaultSigner() signer {
+	sigID := &fakes.Signer{}
+	sigID.SerializeReturns([]byte("signer"), nil)
+	sigID.SignReturns([]byte("signature"), nil)
+	return sigID
+}
+
 // constructUnsignedTxEnv creates a Transaction envelope from given inputs
 func constructUnsignedTxEnv(
 	channelID string,
@@ -149,11 +265,14 @@ func constructUnsignedTxEnv(
 	events []byte,
 	visibility []byte,
 	headerType common.HeaderType,
+	opts ...txEnvOpt,
 ) (*common.Envelope, string, error) {
 
-	sigID := &fakes.Signer{}
-	sigID.SerializeReturns([]byte("signer"), nil)
-	sigID.SignReturns([]byte("signature"), nil)
+	cfg := &txEnvConfig{signer: defaultSigner()}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	sigID := cfg.signer
 
 	ss, err := sigID.Serialize()
 	if err != nil {
@@ -227,12 +346,226 @@ func constructTestGenesisBlock(channelid string) (*common.Block, error) {
 	return blk, nil
 }
 
+// constructUnsignedConfigUpdateTxEnv wraps configUpdate in a ConfigUpdateEnvelope and returns the
+// corresponding HeaderType_CONFIG_UPDATE transaction envelope.
+func constructUnsignedConfigUpdateTxEnv(channelID string, configUpdate *common.ConfigUpdate) (*common.Envelope, error) {
+	configUpdateEnv := &common.ConfigUpdateEnvelope{
+		ConfigUpdate: protoutil.MarshalOrPanic(configUpdate),
+	}
+	return protoutil.CreateSignedEnvelope(
+		common.HeaderType_CONFIG_UPDATE,
+		channelID,
+		defaultSigner(),
+		configUpdateEnv,
+		0,
+		0,
+	)
+}
+
+// constructUnsignedConfigTxEnv wraps config (the channel's resulting configuration) and
+// lastUpdate (the CONFIG_UPDATE envelope that produced it) in a ConfigEnvelope and returns the
+// corresponding HeaderType_CONFIG transaction envelope.
+func constructUnsignedConfigTxEnv(channelID string, config *common.Config, lastUpdate *common.Envelope) (*common.Envelope, error) {
+	configEnv := &common.ConfigEnvelope{
+		Config:     config,
+		LastUpdate: lastUpdate,
+	}
+	return protoutil.CreateSignedEnvelope(
+		common.HeaderType_CONFIG,
+		channelID,
+		defaultSigner(),
+		configEnv,
+		0,
+		0,
+	)
+}
+
+// extractConfigEnvelope pulls the CONFIG transaction's ConfigEnvelope out of a block that was
+// produced by constructTestGenesisBlock or constructConfigBlock.
+func extractConfigEnvelope(block *common.Block) (*common.ConfigEnvelope, error) {
+	env, err := protoutil.GetEnvelopeFromBlock(block.Data.Data[0])
+	if err != nil {
+		return nil, err
+	}
+	configEnv := &common.ConfigEnvelope{}
+	if _, err := protoutil.UnmarshalEnvelopeOfType(env, common.HeaderType_CONFIG, configEnv); err != nil {
+		return nil, err
+	}
+	return configEnv, nil
+}
+
+// constructConfigBlock builds the next block on top of prevBlock (a block whose sole transaction
+// is a CONFIG transaction, e.g. one produced by constructTestGenesisBlock or a prior call to this
+// function), containing a single CONFIG transaction whose Config is computed by applying
+// configUpdate to prevBlock's current configuration. It mirrors setBlockFlagsToValid so the
+// resulting block is ready to be committed to the ledger, letting tests exercise channel-config
+// transitions (org additions, capability upgrades, policy changes) end-to-end.
+func constructConfigBlock(prevBlock *common.Block, configUpdate *common.ConfigUpdate) (*common.Block, error) {
+	channelID, err := protoutil.GetChannelIDFromBlock(prevBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	prevConfigEnv, err := extractConfigEnvelope(prevBlock)
+	if err != nil {
+		return nil, err
+	}
+	newConfig := applyConfigUpdate(prevConfigEnv.Config, configUpdate)
+
+	configUpdateTxEnv, err := constructUnsignedConfigUpdateTxEnv(channelID, configUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	configTxEnv, err := constructUnsignedConfigTxEnv(channelID, newConfig, configUpdateTxEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	block := protoutil.NewBlock(prevBlock.Header.Number+1, protoutil.BlockHeaderHash(prevBlock.Header))
+	block.Data = &common.BlockData{Data: [][]byte{protoutil.MarshalOrPanic(configTxEnv)}}
+	block.Header.DataHash = protoutil.BlockDataHash(block.Data)
+	setBlockFlagsToValid(block)
+	return block, nil
+}
+
+// applyConfigUpdate computes the Config that results from applying configUpdate's write set on
+// top of prevConfig, mirroring (a simplified form of) the channel config-update semantics used
+// elsewhere in fabric: entries untouched by the write set are carried over unchanged, entries it
+// does touch replace/add to prevConfig, values and policies have their version bumped only when
+// their content or mod policy changed, and every group present in the write set is bumped.
+func applyConfigUpdate(prevConfig *common.Config, configUpdate *common.ConfigUpdate) *common.Config {
+	return &common.Config{
+		Sequence:     prevConfig.GetSequence() + 1,
+		ChannelGroup: mergeConfigGroup(prevConfig.GetChannelGroup(), configUpdate.GetWriteSet()),
+	}
+}
+
+// mergeConfigGroup applies writeSet on top of prev. It is called only for groups actually present
+// in a write set, and bumps the group's own version on every such call, whether or not its set of
+// values, policies or subgroups changed.
+func mergeConfigGroup(prev, writeSet *common.ConfigGroup) *common.ConfigGroup {
+	if writeSet == nil {
+		return prev
+	}
+	merged := &common.ConfigGroup{
+		Version:   prev.GetVersion() + 1,
+		ModPolicy: writeSet.ModPolicy,
+		Values:    map[string]*common.ConfigValue{},
+		Policies:  map[string]*common.ConfigPolicy{},
+		Groups:    map[string]*common.ConfigGroup{},
+	}
+	if merged.ModPolicy == "" {
+		merged.ModPolicy = prev.GetModPolicy()
+	}
+
+	for key, value := range prev.GetValues() {
+		merged.Values[key] = value
+	}
+	for key, value := range writeSet.Values {
+		merged.Values[key] = mergeConfigValue(prev.GetValues()[key], value)
+	}
+
+	for key, policy := range prev.GetPolicies() {
+		merged.Policies[key] = policy
+	}
+	for key, policy := range writeSet.Policies {
+		merged.Policies[key] = mergeConfigPolicy(prev.GetPolicies()[key], policy)
+	}
+
+	for key, group := range prev.GetGroups() {
+		merged.Groups[key] = group
+	}
+	for key, group := range writeSet.Groups {
+		merged.Groups[key] = mergeConfigGroup(prev.GetGroups()[key], group)
+	}
+
+	return merged
+}
+
+func mergeConfigValue(prev, write *common.ConfigValue) *common.ConfigValue {
+	version := int64(0)
+	changed := true
+	if prev != nil {
+		version = prev.Version
+		changed = prev.ModPolicy != write.ModPolicy || !bytes.Equal(prev.Value, write.Value)
+	}
+	if changed {
+		version++
+	}
+	return &common.ConfigValue{Version: version, ModPolicy: write.ModPolicy, Value: write.Value}
+}
+
+func mergeConfigPolicy(prev, write *common.ConfigPolicy) *common.ConfigPolicy {
+	version := int64(0)
+	changed := true
+	if prev != nil {
+		version = prev.Version
+		changed = prev.ModPolicy != write.ModPolicy || !proto.Equal(prev.Policy, write.Policy)
+	}
+	if changed {
+		version++
+	}
+	return &common.ConfigPolicy{Version: version, ModPolicy: write.ModPolicy, Policy: write.Policy}
+}
+
 func setBlockFlagsToValid(block *common.Block) {
 	protoutil.InitBlockMetadata(block)
 	block.Metadata.Metadata[common.BlockMetadataIndex_TRANSACTIONS_FILTER] =
 		lutils.NewTxValidationFlagsSetValue(len(block.Data.Data), protopeer.TxValidationCode_VALID)
 }
 
+// stateDBHarness abstracts over the state DB backend a kvledger test runs against, so the same
+// ledger scenario can be parameterized over both the CouchDB rich-query path and the LevelDB
+// fast-path without duplicating setup/teardown logic in every test.
+type stateDBHarness interface {
+	// Setup brings up the backend (e.g., starting a CouchDB container or creating a scratch
+	// directory) and registers any required cleanup via t.Cleanup.
+	Setup(t *testing.T)
+	// Drop clears out all the state accumulated by the tests that ran against this backend,
+	// leaving the backend ready to be reused by the next test.
+	Drop(t *testing.T)
+	// Config returns the ledger.Config to plug into the kvledger.Provider under test. It
+	// includes a backend-private RootFSPath, so every harness can be wired into a real provider
+	// the same way, regardless of where (or whether) the backend keeps files on disk.
+	Config() *ledger.Config
+}
+
+// couchDBHarness is a stateDBHarness backed by a real CouchDB instance started via
+// integration/runner. It exercises the rich-query (CouchDB) state DB code path.
+type couchDBHarness struct {
+	couchdbMountDir string
+	localdHostDir   string
+	config          *ledger.Config
+}
+
+func (h *couchDBHarness) Setup(t *testing.T) {
+	addr, cleanup := couchDBSetup(t, h.couchdbMountDir, h.localdHostDir)
+	t.Cleanup(cleanup)
+	h.config = &ledger.Config{
+		RootFSPath: t.TempDir(),
+		StateDBConfig: &ledger.StateDBConfig{
+			StateDatabase: "CouchDB",
+			CouchDB: &couchdb.Config{
+				Address:             addr,
+				Username:            "",
+				Password:            "",
+				MaxRetries:          3,
+				MaxRetriesOnStartup: 10,
+				RequestTimeout:      35 * time.Second,
+			},
+		},
+	}
+}
+
+func (h *couchDBHarness) Drop(t *testing.T) {
+	dropCouchDBs(t, h.config.StateDBConfig.CouchDB)
+}
+
+func (h *couchDBHarness) Config() *ledger.Config {
+	return h.config
+}
+
 func couchDBSetup(t *testing.T, couchdbMountDir string, localdHostDir string) (addr string, cleanup func()) {
 	couchDB := &runner.CouchDB{
 		Name: "ledger13_upgrade_test",
@@ -263,3 +596,56 @@ func dropCouchDBs(t *testing.T, couchdbConfig *couchdb.Config) {
 		require.True(t, response.Ok)
 	}
 }
+
+// levelDBHarness is a stateDBHarness backed by an in-process stateleveldb instance. It is cheap
+// to set up and exercises the default (LevelDB) state DB code path. The kvledger.Provider itself
+// derives the on-disk goleveldb directory from ledger.Config.RootFSPath (as stateLeveldbDir
+// does below) rather than from any field on ledger.StateDBConfig.
+type levelDBHarness struct {
+	config *ledger.Config
+}
+
+func (h *levelDBHarness) Setup(t *testing.T) {
+	h.config = &ledger.Config{
+		RootFSPath:    t.TempDir(),
+		StateDBConfig: &ledger.StateDBConfig{StateDatabase: "goleveldb"},
+	}
+}
+
+func (h *levelDBHarness) Drop(t *testing.T) {
+	dir := stateLeveldbDir(h.config)
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+}
+
+func (h *levelDBHarness) Config() *ledger.Config {
+	return h.config
+}
+
+// stateLeveldbDir mirrors how kvledger.Provider locates the goleveldb state DB directory for a
+// given ledger.Config, under RootFSPath.
+func stateLeveldbDir(config *ledger.Config) string {
+	return filepath.Join(config.RootFSPath, "stateLeveldb")
+}
+
+// stateDBHarnesses returns one harness per supported state DB backend so a test can iterate
+// over them and run the same scenario against each.
+func stateDBHarnesses(couchdbMountDir, localdHostDir string) map[string]stateDBHarness {
+	return map[string]stateDBHarness{
+		"goleveldb": &levelDBHarness{},
+		"CouchDB":   &couchDBHarness{couchdbMountDir: couchdbMountDir, localdHostDir: localdHostDir},
+	}
+}
+
+// forEachStateDBBackend runs test once per supported state DB backend, handling harness
+// setup/teardown so the test body can focus on the ledger scenario being exercised.
+func forEachStateDBBackend(t *testing.T, couchdbMountDir, localdHostDir string, test func(t *testing.T, h stateDBHarness)) {
+	for name, h := range stateDBHarnesses(couchdbMountDir, localdHostDir) {
+		h := h
+		t.Run(name, func(t *testing.T) {
+			h.Setup(t)
+			defer h.Drop(t)
+			test(t, h)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. All Rights Reserved.
+
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertCollConfig(t *testing.T) {
+	collConfs := []*collConf{
+		{
+			name:              "coll1",
+			btl:               100,
+			members:           []string{"org1", "org2", "org3"},
+			requiredNumOrgs:   2,
+			requiredPeerCount: 1,
+			maximumPeerCount:  3,
+			memberOnlyRead:    true,
+			memberOnlyWrite:   true,
+			endorsers:         []string{"org1", "org2"},
+		},
+		{
+			name:    "coll2",
+			btl:     0,
+			members: []string{"org1", "org2"},
+		},
+	}
+
+	protoBytes, err := convertToCollConfigProtoBytes(collConfs)
+	require.NoError(t, err)
+
+	collConfPkg := &common.CollectionConfigPackage{}
+	require.NoError(t, proto.Unmarshal(protoBytes, collConfPkg))
+
+	actual := convertFromCollConfigProto(collConfPkg)
+	require.Equal(t, []*collConf{
+		{
+			name:              "coll1",
+			btl:               100,
+			members:           []string{"org1", "org2", "org3"},
+			requiredNumOrgs:   2,
+			requiredPeerCount: 1,
+			maximumPeerCount:  3,
+			memberOnlyRead:    true,
+			memberOnlyWrite:   true,
+			endorsers:         []string{"org1", "org2"},
+		},
+		{
+			name:            "coll2",
+			btl:             0,
+			members:         []string{"org1", "org2"},
+			requiredNumOrgs: 1,
+		},
+	}, actual)
+}
+
+func TestConvertToMemberOrgsPolicyRejectsNegativeRequiredNumOrgs(t *testing.T) {
+	_, err := convertToMemberOrgsPolicy([]string{"org1", "org2"}, -1)
+	require.EqualError(t, err, "requiredNumOrgs must be >= 0, got -1")
+}
+
+func TestLevelDBHarness(t *testing.T) {
+	h := &levelDBHarness{}
+	h.Setup(t)
+
+	cfg := h.Config()
+	require.Equal(t, "goleveldb", cfg.StateDBConfig.StateDatabase)
+	require.DirExists(t, cfg.RootFSPath)
+
+	dbDir := stateLeveldbDir(cfg)
+	require.NoError(t, os.MkdirAll(dbDir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dbDir, "marker"), []byte("x"), 0o644))
+
+	h.Drop(t)
+	entries, err := os.ReadDir(dbDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}